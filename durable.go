@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// Default RS(136,128) shard sizes, matching Picocrypt's ratio: 128 bytes of
+// data protected by 8 parity bytes, correcting up to 4 byte errors per
+// frame.
+const (
+	rsDefaultDataSize   = 128
+	rsDefaultParitySize = 8
+)
+
+// WithDurableStorage enables Reed-Solomon protected storage using the
+// default RS(136,128) shard sizes.
+func WithDurableStorage() FilesystemPasteStoreOption {
+	return WithDurableStorageShards(rsDefaultDataSize, rsDefaultParitySize)
+}
+
+// WithDurableStorageShards enables Reed-Solomon protected storage with a
+// custom data/parity shard split.
+func WithDurableStorageShards(dataSize, paritySize int) FilesystemPasteStoreOption {
+	return func(store *FilesystemPasteStore) {
+		store.durable = true
+		store.rs = newRSCodec(dataSize, paritySize)
+	}
+}
+
+// durableWriter buffers bytes into rs.dataSize blocks, Reed-Solomon encodes
+// each one, and writes the resulting data||parity frames to the underlying
+// file. It is placed closest to the file so that encryption (if any) is
+// applied first and parity protects the actual on-disk bytes.
+type durableWriter struct {
+	w      io.WriteCloser
+	rs     *rsCodec
+	paste  *Paste
+	buf    []byte
+	closed bool
+}
+
+func newDurableWriter(w io.WriteCloser, rs *rsCodec, p *Paste) *durableWriter {
+	return &durableWriter{w: w, rs: rs, paste: p}
+}
+
+func (dw *durableWriter) flushBlock(block []byte) error {
+	_, err := dw.w.Write(dw.rs.Encode(block))
+	return err
+}
+
+func (dw *durableWriter) Write(p []byte) (int, error) {
+	dw.buf = append(dw.buf, p...)
+	dw.paste.rsLength += len(p)
+
+	for len(dw.buf) >= dw.rs.dataSize {
+		if err := dw.flushBlock(dw.buf[:dw.rs.dataSize]); err != nil {
+			return 0, err
+		}
+		dw.buf = dw.buf[dw.rs.dataSize:]
+	}
+
+	return len(p), nil
+}
+
+func (dw *durableWriter) Close() error {
+	if dw.closed {
+		return nil
+	}
+	dw.closed = true
+
+	if len(dw.buf) > 0 {
+		padded := make([]byte, dw.rs.dataSize)
+		copy(padded, dw.buf)
+		if err := dw.flushBlock(padded); err != nil {
+			return err
+		}
+		dw.buf = nil
+	}
+
+	return dw.w.Close()
+}
+
+// durableReader decodes Reed-Solomon frames back into the original byte
+// stream, stopping at the recorded plaintext length so block padding never
+// leaks into the result. When AttemptFix is set, a frame that exceeds the
+// code's correction capacity yields its raw (still possibly corrupt) data
+// bytes instead of failing the read; Unrecoverable counts how often that
+// happened.
+type durableReader struct {
+	r             io.ReadCloser
+	rs            *rsCodec
+	remaining     int
+	buf           []byte
+	AttemptFix    bool
+	Unrecoverable int
+}
+
+func newDurableReader(r io.ReadCloser, rs *rsCodec, length int) *durableReader {
+	return &durableReader{r: r, rs: rs, remaining: length}
+}
+
+func (dr *durableReader) Read(p []byte) (int, error) {
+	for len(dr.buf) == 0 {
+		if dr.remaining <= 0 {
+			return 0, io.EOF
+		}
+
+		frame := make([]byte, dr.rs.frameSize())
+		if _, err := io.ReadFull(dr.r, frame); err != nil {
+			return 0, err
+		}
+
+		block, _, err := dr.rs.Decode(frame)
+		if err != nil {
+			dr.Unrecoverable++
+			if !dr.AttemptFix {
+				return 0, err
+			}
+			block = frame[:dr.rs.dataSize]
+		}
+
+		n := dr.rs.dataSize
+		if dr.remaining < n {
+			n = dr.remaining
+		}
+		dr.buf = block[:n]
+		dr.remaining -= n
+	}
+
+	n := copy(p, dr.buf)
+	dr.buf = dr.buf[n:]
+	return n, nil
+}
+
+func (dr *durableReader) Close() error {
+	return dr.r.Close()
+}
+
+// UnrecoverableFrames reports how many Reed-Solomon frames read so far
+// exceeded the code's correction capacity. It is zero for pastes not stored
+// in durable mode.
+func (pr *PasteReader) UnrecoverableFrames() int {
+	if dr, ok := pr.ReadCloser.(*durableReader); ok {
+		return dr.Unrecoverable
+	}
+	return 0
+}
+
+// SetAttemptFix toggles "-f" style best-effort recovery: when enabled, a
+// frame beyond the code's correction capacity returns its raw bytes plus a
+// warning (via UnrecoverableFrames) instead of failing the read outright.
+func (pr *PasteReader) SetAttemptFix(attempt bool) {
+	if dr, ok := pr.ReadCloser.(*durableReader); ok {
+		dr.AttemptFix = attempt
+	}
+}
+
+// Repair rewrites a durably-stored paste's file frame by frame, replacing
+// each with its corrected data||parity encoding. It fails if any frame has
+// more errors than the code can correct.
+func (store *FilesystemPasteStore) Repair(p *Paste) error {
+	if !store.durable {
+		return errors.New("spectre: durable storage is not enabled on this store")
+	}
+
+	filename := store.filenameForID(p.ID)
+	in, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmpFilename := filename + ".repair"
+	out, err := os.Create(tmpFilename)
+	if err != nil {
+		return err
+	}
+
+	if err := store.repairFrames(in, out); err != nil {
+		out.Close()
+		os.Remove(tmpFilename)
+		return err
+	}
+
+	if err := out.Close(); err != nil {
+		os.Remove(tmpFilename)
+		return err
+	}
+
+	return os.Rename(tmpFilename, filename)
+}
+
+func (store *FilesystemPasteStore) repairFrames(in io.Reader, out io.Writer) error {
+	frameSize := store.rs.frameSize()
+	for {
+		frame := make([]byte, frameSize)
+		n, err := io.ReadFull(in, frame)
+		if n == 0 && err == io.EOF {
+			return nil
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		block, _, err := store.rs.Decode(frame[:n])
+		if err != nil {
+			return err
+		}
+
+		if _, err := out.Write(store.rs.Encode(block)); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func writeDurable(t *testing.T, rs *rsCodec, p *Paste, plaintext []byte) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	dw := newDurableWriter(nopCloser{buf}, rs, p)
+	if _, err := dw.Write(plaintext); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := dw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestDurableRoundTrip(t *testing.T) {
+	rs := newRSCodec(rsDefaultDataSize, rsDefaultParitySize)
+
+	for _, size := range []int{0, 1, rs.dataSize, rs.dataSize + 1, 3*rs.dataSize + 5} {
+		plaintext := make([]byte, size)
+		for i := range plaintext {
+			plaintext[i] = byte(i)
+		}
+
+		p := &Paste{}
+		encoded := writeDurable(t, rs, p, plaintext)
+
+		dr := newDurableReader(ioutil.NopCloser(bytes.NewReader(encoded)), rs, p.rsLength)
+		got, err := ioutil.ReadAll(dr)
+		if err != nil {
+			t.Fatalf("size %d: ReadAll: %v", size, err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Fatalf("size %d: round-trip mismatch", size)
+		}
+	}
+}
+
+// TestDurableReaderCorrectsWithinCapacity checks that a durableReader
+// transparently corrects up to the code's correction capacity (4 byte
+// errors per RS(136,128) frame) without AttemptFix.
+func TestDurableReaderCorrectsWithinCapacity(t *testing.T) {
+	rs := newRSCodec(rsDefaultDataSize, rsDefaultParitySize)
+	plaintext := bytes.Repeat([]byte("x"), rs.dataSize)
+
+	p := &Paste{}
+	encoded := writeDurable(t, rs, p, plaintext)
+	for _, pos := range []int{0, 10, 50, 130} {
+		encoded[pos] ^= 0xff
+	}
+
+	dr := newDurableReader(ioutil.NopCloser(bytes.NewReader(encoded)), rs, p.rsLength)
+	got, err := ioutil.ReadAll(dr)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatal("round-trip mismatch after correctable corruption")
+	}
+	if dr.Unrecoverable != 0 {
+		t.Fatalf("expected 0 unrecoverable frames, got %d", dr.Unrecoverable)
+	}
+}
+
+// TestDurableReaderOverCapacity checks that a frame with more errors than
+// the code can correct fails outright without AttemptFix, and that
+// AttemptFix turns that failure into raw (possibly-corrupt) data plus an
+// UnrecoverableFrames count instead of an error.
+func TestDurableReaderOverCapacity(t *testing.T) {
+	rs := newRSCodec(rsDefaultDataSize, rsDefaultParitySize)
+	plaintext := bytes.Repeat([]byte("y"), rs.dataSize)
+
+	p := &Paste{}
+	encoded := writeDurable(t, rs, p, plaintext)
+	for _, pos := range []int{0, 10, 50, 130, 135} {
+		encoded[pos] ^= 0xff
+	}
+
+	dr := newDurableReader(ioutil.NopCloser(bytes.NewReader(encoded)), rs, p.rsLength)
+	if _, err := ioutil.ReadAll(dr); err == nil {
+		t.Fatal("expected an error reading a frame with 5 byte errors, got nil")
+	}
+
+	dr = newDurableReader(ioutil.NopCloser(bytes.NewReader(encoded)), rs, p.rsLength)
+	dr.AttemptFix = true
+	if _, err := ioutil.ReadAll(dr); err != nil {
+		t.Fatalf("AttemptFix: unexpected error %v", err)
+	}
+	if dr.Unrecoverable != 1 {
+		t.Fatalf("expected 1 unrecoverable frame, got %d", dr.Unrecoverable)
+	}
+}
+
+// TestDurableEncryptedRoundTrip lays the STREAM encryption layer on top of
+// durable storage the same way FilesystemPasteStore.writeStream/readStream
+// order them, guarding against rsLength being recorded before the
+// encryption layer has sealed (and so written) its final chunk.
+func TestDurableEncryptedRoundTrip(t *testing.T) {
+	rs := newRSCodec(rsDefaultDataSize, rsDefaultParitySize)
+	key := deriveFileKey([]byte("durable-encrypted-key"))
+
+	for _, size := range []int{0, 1, 100, streamChunkSize, streamChunkSize + 1} {
+		plaintext := make([]byte, size)
+		for i := range plaintext {
+			plaintext[i] = byte(i)
+		}
+
+		p := &Paste{}
+		buf := &bytes.Buffer{}
+		dw := newDurableWriter(nopCloser{buf}, rs, p)
+		sw, err := newStreamWriter(dw, key)
+		if err != nil {
+			t.Fatalf("size %d: newStreamWriter: %v", size, err)
+		}
+		if _, err := sw.Write(plaintext); err != nil {
+			t.Fatalf("size %d: Write: %v", size, err)
+		}
+		if err := sw.Close(); err != nil {
+			t.Fatalf("size %d: Close: %v", size, err)
+		}
+
+		if p.rsLength == 0 && size > 0 {
+			t.Fatalf("size %d: rsLength was never recorded", size)
+		}
+
+		dr := newDurableReader(ioutil.NopCloser(bytes.NewReader(buf.Bytes())), rs, p.rsLength)
+		sr, err := newStreamReader(dr, key)
+		if err != nil {
+			t.Fatalf("size %d: newStreamReader: %v", size, err)
+		}
+		got, err := ioutil.ReadAll(sr)
+		if err != nil {
+			t.Fatalf("size %d: ReadAll: %v", size, err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Fatalf("size %d: durable+encrypted round-trip mismatch", size)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+// GF(256) arithmetic over the same primitive polynomial (x^8+x^4+x^3+x^2+1,
+// 0x11d) used by QR codes and CCITT, with generator element 2. Polynomial
+// coefficients throughout this package are ordered from the highest-degree
+// term to the lowest, i.e. in the same order the bytes appear on the wire.
+
+const gfPrimitivePoly = 0x11d
+
+var gfExpTable [512]byte
+var gfLogTable [256]byte
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExpTable[i] = byte(x)
+		gfLogTable[byte(x)] = byte(i)
+		x <<= 1
+		if x >= 256 {
+			x ^= gfPrimitivePoly
+		}
+	}
+	for i := 255; i < len(gfExpTable); i++ {
+		gfExpTable[i] = gfExpTable[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExpTable[int(gfLogTable[a])+int(gfLogTable[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExpTable[(int(gfLogTable[a])+255-int(gfLogTable[b]))%255]
+}
+
+func gfPow(a byte, n int) byte {
+	e := (int(gfLogTable[a]) * n) % 255
+	if e < 0 {
+		e += 255
+	}
+	return gfExpTable[e]
+}
+
+func gfInverse(a byte) byte {
+	return gfExpTable[255-int(gfLogTable[a])]
+}
+
+func gfPolyMul(p, q []byte) []byte {
+	r := make([]byte, len(p)+len(q)-1)
+	for j := range q {
+		if q[j] == 0 {
+			continue
+		}
+		for i := range p {
+			r[i+j] ^= gfMul(p[i], q[j])
+		}
+	}
+	return r
+}
+
+// gfPolyAdd adds (xors) two polynomials, aligning them on their
+// lowest-degree (rightmost) term.
+func gfPolyAdd(p, q []byte) []byte {
+	n := len(p)
+	if len(q) > n {
+		n = len(q)
+	}
+
+	r := make([]byte, n)
+	copy(r[n-len(p):], p)
+	for i, c := range q {
+		r[n-len(q)+i] ^= c
+	}
+	return r
+}
+
+func gfPolyScale(p []byte, x byte) []byte {
+	r := make([]byte, len(p))
+	for i, c := range p {
+		r[i] = gfMul(c, x)
+	}
+	return r
+}
+
+func gfPolyEval(p []byte, x byte) byte {
+	y := p[0]
+	for i := 1; i < len(p); i++ {
+		y = gfMul(y, x) ^ p[i]
+	}
+	return y
+}
+
+func reversePoly(p []byte) []byte {
+	r := make([]byte, len(p))
+	for i, c := range p {
+		r[len(p)-1-i] = c
+	}
+	return r
+}
@@ -0,0 +1,101 @@
+package main
+
+import "strconv"
+
+// metadataStore abstracts per-paste metadata storage so the Save/Get logic
+// in this file doesn't need to know whether a paste's language, hmac, kdf
+// and rs fields live in filesystem xattrs or S3 object user metadata.
+type metadataStore interface {
+	putMetadata(name, value string) error
+	getMetadata(name, dflt string) string
+}
+
+// xattrMetadataStore is the metadataStore backing FilesystemPasteStore.
+type xattrMetadataStore struct {
+	filename string
+}
+
+func (m xattrMetadataStore) putMetadata(name, value string) error {
+	return putMetadata(m.filename, name, value)
+}
+
+func (m xattrMetadataStore) getMetadata(name, dflt string) string {
+	return getMetadata(m.filename, name, dflt)
+}
+
+// savePasteMetadata writes the metadata fields common to every PasteStore
+// backend: language, the recipient-header marker, and (for encrypted
+// pastes) the HMAC-over-ID check, encryption version and KDF descriptor.
+// durable/rsLength are only persisted when the store enables RS-protected
+// storage.
+func savePasteMetadata(ms metadataStore, p *Paste, durable bool) error {
+	if err := ms.putMetadata("language", p.Language); err != nil {
+		return err
+	}
+
+	if durable {
+		if err := ms.putMetadata("rs", "1"); err != nil {
+			return err
+		}
+		if err := ms.putMetadata("rs_length", strconv.Itoa(p.rsLength)); err != nil {
+			return err
+		}
+	}
+
+	if p.recipientHeader != nil {
+		if err := ms.putMetadata("recipients", "1"); err != nil {
+			return err
+		}
+	}
+
+	if p.Encrypted {
+		hmacBytes := constructMAC([]byte(p.ID.String()), p.encryptionKey)
+		if err := ms.putMetadata("hmac", base32Encoder.EncodeToString(hmacBytes)); err != nil {
+			return err
+		}
+
+		if err := ms.putMetadata("encryption_version", ENCRYPTION_VERSION); err != nil {
+			return err
+		}
+
+		if p.kdf != "" {
+			if err := ms.putMetadata("kdf", p.kdf); err != nil {
+				return err
+			}
+			if err := ms.putMetadata("kdf_salt", base32Encoder.EncodeToString(p.kdfSalt)); err != nil {
+				return err
+			}
+			if err := ms.putMetadata("kdf_params", p.kdfParams.String()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkEncryptedMetadata inspects a paste's stored hmac and, if one is
+// present, validates the caller-supplied key against it the same way every
+// backend's Get does: PasteEncryptedError when no key was given, and
+// PasteInvalidKeyError on a mismatch.
+func checkEncryptedMetadata(ms metadataStore, id PasteID, key []byte) (encrypted bool, encryptionKey []byte, err error) {
+	hmac := ms.getMetadata("hmac", "")
+	if hmac == "" {
+		return false, nil, nil
+	}
+
+	if key == nil {
+		return true, nil, PasteEncryptedError{ID: id}
+	}
+
+	hmacBytes, err := base32Encoder.DecodeString(hmac)
+	if err != nil {
+		return true, nil, err
+	}
+
+	if !checkMAC([]byte(id.String()), hmacBytes, key) {
+		return true, nil, PasteInvalidKeyError{ID: id}
+	}
+
+	return true, key, nil
+}
@@ -9,16 +9,21 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 )
 
 var base32Encoder = base32.NewEncoding("abcdefghjkmnopqrstuvwxyz23456789")
 
-const ENCRYPTION_VERSION string = "1"
+const ENCRYPTION_VERSION string = "2"
 
 type PasteStore interface {
 	New(PasteID, []byte) (*Paste, error)
 	Get(PasteID, []byte) (*Paste, error)
+	NewWithPassphrase(PasteID, string) (*Paste, error)
+	GetWithPassphrase(PasteID, string) (*Paste, error)
+	NewWithRecipients(PasteID, []Recipient) (*Paste, error)
+	GetWithIdentity(PasteID, Identity) (*Paste, error)
 	Save(*Paste) error
 	Destroy(*Paste) error
 
@@ -70,9 +75,19 @@ type PasteWriter struct {
 	paste *Paste
 }
 
+// Close saves the paste's metadata, then closes the underlying writer. For
+// durable stores, the underlying streamWriter/durableWriter chain only seals
+// its final chunk (and so only learns the true on-disk length) during this
+// Close, so the first Save can persist a short rsLength; Close re-saves
+// afterwards to correct it once the writer has fully flushed.
 func (pr *PasteWriter) Close() error {
 	pr.paste.Save()
-	return pr.WriteCloser.Close()
+
+	if err := pr.WriteCloser.Close(); err != nil {
+		return err
+	}
+
+	return pr.paste.Save()
 }
 
 type Paste struct {
@@ -83,6 +98,14 @@ type Paste struct {
 
 	Encrypted     bool
 	encryptionKey []byte
+
+	kdf       string
+	kdfSalt   []byte
+	kdfParams kdfParams
+
+	rsLength int
+
+	recipientHeader []byte
 }
 
 func (p *Paste) Save() error {
@@ -110,16 +133,29 @@ type FilesystemPasteStore struct {
 	PasteUpdateCallback  PasteCallback
 	PasteDestroyCallback PasteCallback
 	path                 string
+
+	durable bool
+	rs      *rsCodec
 }
 
 func noopPasteCallback(p *Paste) {}
 
-func NewFilesystemPasteStore(path string) *FilesystemPasteStore {
-	return &FilesystemPasteStore{
+// FilesystemPasteStoreOption configures optional behavior of a
+// FilesystemPasteStore, such as durable (Reed-Solomon protected) storage.
+type FilesystemPasteStoreOption func(*FilesystemPasteStore)
+
+func NewFilesystemPasteStore(path string, opts ...FilesystemPasteStoreOption) *FilesystemPasteStore {
+	store := &FilesystemPasteStore{
 		path:                 path,
 		PasteUpdateCallback:  PasteCallback(noopPasteCallback),
 		PasteDestroyCallback: PasteCallback(noopPasteCallback),
 	}
+
+	for _, opt := range opts {
+		opt(store)
+	}
+
+	return store
 }
 
 func generatePasteID() (PasteID, error) {
@@ -169,34 +205,14 @@ func (store *FilesystemPasteStore) Get(id PasteID, key []byte) (p *Paste, err er
 	}
 
 	paste := &Paste{ID: id, store: store, mtime: stat.ModTime()}
+	ms := xattrMetadataStore{filename: filename}
 
-	hmac := getMetadata(filename, "hmac", "")
-	if hmac != "" {
-		paste.Encrypted = true
-
-		err = PasteEncryptedError{ID: id}
-		if key != nil {
-			err = nil
-
-			hmacBytes, e := base32Encoder.DecodeString(hmac)
-			if e != nil {
-				err = e
-				return
-			}
-
-			ok := checkMAC([]byte(id.String()), hmacBytes, key)
-
-			if !ok {
-				err = PasteInvalidKeyError{ID: id}
-				return
-			}
-
-			paste.encryptionKey = key
-			err = nil
-		}
+	paste.Encrypted, paste.encryptionKey, err = checkEncryptedMetadata(ms, id, key)
+	if err != nil {
+		return
 	}
 
-	paste.Language = getMetadata(filename, "language", "text")
+	paste.Language = ms.getMetadata("language", "text")
 
 	store.PasteUpdateCallback(paste)
 
@@ -206,20 +222,9 @@ func (store *FilesystemPasteStore) Get(id PasteID, key []byte) (p *Paste, err er
 
 func (store *FilesystemPasteStore) Save(p *Paste) error {
 	filename := store.filenameForID(p.ID)
-	if err := putMetadata(filename, "language", p.Language); err != nil {
-		return err
-	}
-
-	if p.Encrypted {
-		hmacBytes := constructMAC([]byte(p.ID.String()), p.encryptionKey)
-		hmac := base32Encoder.EncodeToString(hmacBytes)
-		if err := putMetadata(filename, "hmac", hmac); err != nil {
-			return err
-		}
 
-		if err := putMetadata(filename, "encryption_version", ENCRYPTION_VERSION); err != nil {
-			return err
-		}
+	if err := savePasteMetadata(xattrMetadataStore{filename: filename}, p, store.durable); err != nil {
+		return err
 	}
 
 	store.PasteUpdateCallback(p)
@@ -244,12 +249,34 @@ func (store *FilesystemPasteStore) readStream(p *Paste) (*PasteReader, error) {
 		return nil, err
 	}
 
+	if getMetadata(filename, "recipients", "") == "1" {
+		if _, _, err := readRecipientHeader(r); err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+
+	if store.durable && getMetadata(filename, "rs", "") == "1" {
+		length, _ := strconv.Atoi(getMetadata(filename, "rs_length", "0"))
+		r = newDurableReader(r, store.rs, length)
+	}
+
 	if p.Encrypted {
-		blockCipher, _ := aes.NewCipher(p.encryptionKey)
-		var iv [aes.BlockSize]byte
-		stream := cipher.NewOFB(blockCipher, iv[:])
-		streamReader := &cipher.StreamReader{S: stream, R: r}
-		r = &ReadCloser{Reader: streamReader, Closer: r}
+		switch getMetadata(filename, "encryption_version", "1") {
+		case "2":
+			sr, err := newStreamReader(r, deriveFileKey(p.encryptionKey))
+			if err != nil {
+				r.Close()
+				return nil, err
+			}
+			r = sr
+		default:
+			blockCipher, _ := aes.NewCipher(p.encryptionKey)
+			var iv [aes.BlockSize]byte
+			stream := cipher.NewOFB(blockCipher, iv[:])
+			streamReader := &cipher.StreamReader{S: stream, R: r}
+			r = &ReadCloser{Reader: streamReader, Closer: r}
+		}
 	}
 
 	return &PasteReader{ReadCloser: r, paste: p}, nil
@@ -263,12 +290,24 @@ func (store *FilesystemPasteStore) writeStream(p *Paste) (*PasteWriter, error) {
 		return nil, err
 	}
 
+	if p.recipientHeader != nil {
+		if _, err := w.Write(p.recipientHeader); err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+
+	if store.durable {
+		w = newDurableWriter(w, store.rs, p)
+	}
+
 	if p.Encrypted {
-		blockCipher, _ := aes.NewCipher(p.encryptionKey)
-		var iv [aes.BlockSize]byte
-		stream := cipher.NewOFB(blockCipher, iv[:])
-		streamWriter := &cipher.StreamWriter{S: stream, W: w}
-		w = &WriteCloser{Writer: streamWriter, Closer: w}
+		sw, err := newStreamWriter(w, deriveFileKey(p.encryptionKey))
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		w = sw
 	}
 
 	return &PasteWriter{WriteCloser: w, paste: p}, nil
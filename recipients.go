@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+const x25519HKDFInfo = "spectre-x25519"
+const recipientHeaderMagic = "SPX1"
+
+var errNoIdentityUnwrapped = errors.New("spectre: no identity could unwrap this paste's file key")
+
+// Stanza is one (recipient, wrapped file key) entry in a multi-recipient
+// paste's header: the file key wrapped so only the matching Recipient's
+// Identity can recover it.
+type Stanza struct {
+	RecipientID string
+	WrappedKey  []byte
+}
+
+// Recipient wraps a freshly generated paste file key under its own public
+// key, given the file's shared ephemeral X25519 keypair (one ephemeral pair
+// is reused for every recipient of a paste, as in age's X25519 stanzas).
+type Recipient interface {
+	ID() string
+	Wrap(ephemeralPriv, ephemeralPub [32]byte, fileKey []byte) (Stanza, error)
+}
+
+// Identity recovers a paste's file key from its recipient stanzas.
+//
+// Raw-key (PasteStore.Get) and passphrase (GetWithPassphrase) pastes don't
+// implement this interface: they authenticate via the existing
+// HMAC-over-ID check rather than a stanza header, so they stay on their own
+// entry points instead of being forced through Unwrap.
+type Identity interface {
+	Unwrap(ephemeralPub [32]byte, stanzas []Stanza) ([]byte, error)
+}
+
+// X25519Recipient wraps a paste's file key for a single X25519 public key.
+type X25519Recipient struct {
+	publicKey [32]byte
+}
+
+func NewX25519Recipient(publicKey [32]byte) *X25519Recipient {
+	return &X25519Recipient{publicKey: publicKey}
+}
+
+func (r *X25519Recipient) ID() string {
+	return base32Encoder.EncodeToString(r.publicKey[:])
+}
+
+func (r *X25519Recipient) Wrap(ephemeralPriv, ephemeralPub [32]byte, fileKey []byte) (Stanza, error) {
+	shared, err := curve25519.X25519(ephemeralPriv[:], r.publicKey[:])
+	if err != nil {
+		return Stanza{}, err
+	}
+
+	wrapKey, err := x25519WrapKey(shared, ephemeralPub[:], r.publicKey[:])
+	if err != nil {
+		return Stanza{}, err
+	}
+
+	aead, err := chacha20poly1305.New(wrapKey)
+	if err != nil {
+		return Stanza{}, err
+	}
+
+	var nonce [chacha20poly1305.NonceSize]byte
+	return Stanza{
+		RecipientID: r.ID(),
+		WrappedKey:  aead.Seal(nil, nonce[:], fileKey, nil),
+	}, nil
+}
+
+// X25519Identity unwraps a paste's file key with an X25519 private key.
+type X25519Identity struct {
+	privateKey [32]byte
+}
+
+func NewX25519Identity(privateKey [32]byte) *X25519Identity {
+	return &X25519Identity{privateKey: privateKey}
+}
+
+func (id *X25519Identity) Unwrap(ephemeralPub [32]byte, stanzas []Stanza) ([]byte, error) {
+	publicKey, err := curve25519.X25519(id.privateKey[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := curve25519.X25519(id.privateKey[:], ephemeralPub[:])
+	if err != nil {
+		return nil, err
+	}
+
+	wrapKey, err := x25519WrapKey(shared, ephemeralPub[:], publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.New(wrapKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce [chacha20poly1305.NonceSize]byte
+	for _, stanza := range stanzas {
+		if fileKey, err := aead.Open(nil, nonce[:], stanza.WrappedKey, nil); err == nil {
+			return fileKey, nil
+		}
+	}
+
+	return nil, errNoIdentityUnwrapped
+}
+
+func x25519WrapKey(shared, ephemeralPub, recipientPub []byte) ([]byte, error) {
+	salt := append(append([]byte(nil), ephemeralPub...), recipientPub...)
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(x25519HKDFInfo)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// generateEphemeralX25519 produces the single ephemeral keypair shared by
+// every recipient stanza of one paste.
+func generateEphemeralX25519() (priv, pub [32]byte, err error) {
+	if _, err = io.ReadFull(rand.Reader, priv[:]); err != nil {
+		return priv, pub, err
+	}
+
+	pubSlice, err := curve25519.X25519(priv[:], curve25519.Basepoint)
+	if err != nil {
+		return priv, pub, err
+	}
+	copy(pub[:], pubSlice)
+
+	return priv, pub, nil
+}
+
+// buildRecipientHeader generates a random 32-byte file key, wraps it for
+// every recipient under one shared ephemeral X25519 keypair, and serializes
+// the result as a small header meant to be prefixed to the paste's on-disk
+// bytes (not stored in xattrs, so it survives rsync/tar).
+func buildRecipientHeader(recipients []Recipient) (fileKey, header []byte, err error) {
+	fileKey = make([]byte, 32)
+	if _, err = io.ReadFull(rand.Reader, fileKey); err != nil {
+		return nil, nil, err
+	}
+
+	ephemeralPriv, ephemeralPub, err := generateEphemeralX25519()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stanzas := make([]Stanza, len(recipients))
+	for i, recipient := range recipients {
+		stanza, err := recipient.Wrap(ephemeralPriv, ephemeralPub, fileKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		stanzas[i] = stanza
+	}
+
+	return fileKey, serializeRecipientHeader(ephemeralPub, stanzas), nil
+}
+
+// serializeRecipientHeader lays out: magic, ephemeral public key, a stanza
+// count, then each stanza as (recipient ID length + bytes, wrapped key
+// length + bytes).
+func serializeRecipientHeader(ephemeralPub [32]byte, stanzas []Stanza) []byte {
+	buf := make([]byte, 0, len(recipientHeaderMagic)+32+2)
+	buf = append(buf, recipientHeaderMagic...)
+	buf = append(buf, ephemeralPub[:]...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(stanzas)))
+
+	for _, s := range stanzas {
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(s.RecipientID)))
+		buf = append(buf, s.RecipientID...)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(s.WrappedKey)))
+		buf = append(buf, s.WrappedKey...)
+	}
+
+	return buf
+}
+
+// readRecipientHeader parses a header written by serializeRecipientHeader
+// directly off r, leaving r positioned at the start of the paste body.
+func readRecipientHeader(r io.Reader) (ephemeralPub [32]byte, stanzas []Stanza, err error) {
+	magic := make([]byte, len(recipientHeaderMagic))
+	if _, err = io.ReadFull(r, magic); err != nil {
+		return
+	}
+	if string(magic) != recipientHeaderMagic {
+		return ephemeralPub, nil, errors.New("spectre: not a recipient-wrapped paste")
+	}
+
+	if _, err = io.ReadFull(r, ephemeralPub[:]); err != nil {
+		return
+	}
+
+	var count uint16
+	if err = binary.Read(r, binary.BigEndian, &count); err != nil {
+		return
+	}
+
+	stanzas = make([]Stanza, count)
+	for i := range stanzas {
+		id, err2 := readLengthPrefixed(r)
+		if err2 != nil {
+			return ephemeralPub, nil, err2
+		}
+		key, err2 := readLengthPrefixed(r)
+		if err2 != nil {
+			return ephemeralPub, nil, err2
+		}
+		stanzas[i] = Stanza{RecipientID: string(id), WrappedKey: key}
+	}
+
+	return ephemeralPub, stanzas, nil
+}
+
+func readLengthPrefixed(r io.Reader) ([]byte, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
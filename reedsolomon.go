@@ -0,0 +1,231 @@
+package main
+
+import "errors"
+
+// errRSUncorrectable is returned when a frame carries more byte errors than
+// its parity can correct.
+var errRSUncorrectable = errors.New("spectre: reed-solomon frame has too many errors to correct")
+
+// rsCodec is a classical RS(dataSize+paritySize, dataSize) code over
+// GF(256): every frame is one codeword, encoded with a generator polynomial
+// and decoded with syndromes, Berlekamp-Massey and Forney correction. It can
+// correct up to paritySize/2 byte errors per frame without knowing their
+// positions in advance.
+type rsCodec struct {
+	dataSize   int
+	paritySize int
+	generator  []byte
+}
+
+func newRSCodec(dataSize, paritySize int) *rsCodec {
+	return &rsCodec{
+		dataSize:   dataSize,
+		paritySize: paritySize,
+		generator:  rsGeneratorPoly(paritySize),
+	}
+}
+
+func (rs *rsCodec) frameSize() int {
+	return rs.dataSize + rs.paritySize
+}
+
+func rsGeneratorPoly(nsym int) []byte {
+	g := []byte{1}
+	for i := 0; i < nsym; i++ {
+		g = gfPolyMul(g, []byte{1, gfPow(2, i)})
+	}
+	return g
+}
+
+// Encode appends paritySize parity bytes to a dataSize-byte block, returning
+// the full on-disk frame.
+func (rs *rsCodec) Encode(data []byte) []byte {
+	frame := make([]byte, rs.frameSize())
+	copy(frame, data)
+
+	for i := 0; i < rs.dataSize; i++ {
+		coef := frame[i]
+		if coef == 0 {
+			continue
+		}
+		for j, g := range rs.generator {
+			frame[i+j] ^= gfMul(g, coef)
+		}
+	}
+
+	copy(frame, data)
+	return frame
+}
+
+// Decode authenticates and corrects a frame, returning the recovered
+// dataSize-byte block and the number of byte errors that were corrected.
+// It returns errRSUncorrectable if the frame has more errors than the code
+// can fix.
+func (rs *rsCodec) Decode(frame []byte) (data []byte, corrected int, err error) {
+	msg := make([]byte, len(frame))
+	copy(msg, frame)
+
+	synd := rs.syndromes(msg)
+	if rsAllZero(synd) {
+		return msg[:rs.dataSize], 0, nil
+	}
+
+	errLoc, err := rs.findErrorLocator(synd)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	errPos, err := rs.findErrors(errLoc, len(msg))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err := rs.correctErrata(msg, synd, errPos); err != nil {
+		return nil, 0, err
+	}
+
+	if !rsAllZero(rs.syndromes(msg)) {
+		return nil, 0, errRSUncorrectable
+	}
+
+	return msg[:rs.dataSize], len(errPos), nil
+}
+
+func rsAllZero(p []byte) bool {
+	for _, b := range p {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// syndromes evaluates msg at each root of the generator polynomial. A
+// leading zero is kept so index arithmetic in findErrorLocator lines up with
+// the reference Berlekamp-Massey derivation.
+func (rs *rsCodec) syndromes(msg []byte) []byte {
+	synd := make([]byte, rs.paritySize+1)
+	for i := 0; i < rs.paritySize; i++ {
+		synd[i+1] = gfPolyEval(msg, gfPow(2, i))
+	}
+	return synd
+}
+
+func (rs *rsCodec) findErrorLocator(synd []byte) ([]byte, error) {
+	nsym := rs.paritySize
+	errLoc := []byte{1}
+	oldLoc := []byte{1}
+
+	syndShift := 0
+	if len(synd) > nsym {
+		syndShift = len(synd) - nsym
+	}
+
+	for i := 0; i < nsym; i++ {
+		k := i + syndShift
+		delta := synd[k]
+		for j := 1; j < len(errLoc); j++ {
+			delta ^= gfMul(errLoc[len(errLoc)-1-j], synd[k-j])
+		}
+
+		oldLoc = append(oldLoc, 0)
+
+		if delta != 0 {
+			if len(oldLoc) > len(errLoc) {
+				newLoc := gfPolyScale(oldLoc, delta)
+				oldLoc = gfPolyScale(errLoc, gfInverse(delta))
+				errLoc = newLoc
+			}
+			errLoc = gfPolyAdd(errLoc, gfPolyScale(oldLoc, delta))
+		}
+	}
+
+	for len(errLoc) > 1 && errLoc[0] == 0 {
+		errLoc = errLoc[1:]
+	}
+
+	if (len(errLoc)-1)*2 > nsym {
+		return nil, errRSUncorrectable
+	}
+
+	return errLoc, nil
+}
+
+// findErrors runs a Chien search for the roots of the error locator
+// polynomial, returning each error's byte offset in msg.
+func (rs *rsCodec) findErrors(errLoc []byte, nmess int) ([]int, error) {
+	ascending := reversePoly(errLoc)
+	errs := len(errLoc) - 1
+
+	var errPos []int
+	for i := 0; i < nmess; i++ {
+		if gfPolyEval(ascending, gfPow(2, i)) == 0 {
+			errPos = append(errPos, nmess-1-i)
+		}
+	}
+
+	if len(errPos) != errs {
+		return nil, errRSUncorrectable
+	}
+
+	return errPos, nil
+}
+
+// correctErrata applies the Forney algorithm to compute each error's
+// magnitude and XORs the corrections into msg in place.
+func (rs *rsCodec) correctErrata(msg []byte, synd []byte, errPos []int) error {
+	coefPos := make([]int, len(errPos))
+	for i, p := range errPos {
+		coefPos[i] = len(msg) - 1 - p
+	}
+
+	errLoc := []byte{1}
+	for _, i := range coefPos {
+		errLoc = gfPolyMul(errLoc, gfPolyAdd([]byte{1}, []byte{gfPow(2, i), 0}))
+	}
+
+	nsym := len(errLoc) - 1
+	product := gfPolyMul(reversePoly(synd), errLoc)
+	errEval := reversePoly(rsTruncateLow(product, nsym+1))
+
+	x := make([]byte, len(coefPos))
+	for i, pos := range coefPos {
+		x[i] = gfPow(2, -(255 - pos))
+	}
+
+	e := make([]byte, len(msg))
+	evalDescending := reversePoly(errEval)
+	for i, xi := range x {
+		xiInv := gfInverse(xi)
+
+		errLocPrime := byte(1)
+		for j, xj := range x {
+			if j == i {
+				continue
+			}
+			errLocPrime = gfMul(errLocPrime, 1^gfMul(xiInv, xj))
+		}
+		if errLocPrime == 0 {
+			return errRSUncorrectable
+		}
+
+		y := gfMul(xi, gfPolyEval(evalDescending, xiInv))
+		e[errPos[i]] = gfDiv(y, errLocPrime)
+	}
+
+	copy(msg, gfPolyAdd(msg, e))
+	return nil
+}
+
+// rsTruncateLow returns the remainder of poly modulo x^n, i.e. its lowest n
+// coefficients (or the whole polynomial if it is already shorter).
+func rsTruncateLow(poly []byte, n int) []byte {
+	if len(poly) <= n {
+		r := make([]byte, len(poly))
+		copy(r, poly)
+		return r
+	}
+	r := make([]byte, n)
+	copy(r, poly[len(poly)-n:])
+	return r
+}
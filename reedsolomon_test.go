@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func corruptN(t *testing.T, rs *rsCodec, frame []byte, n int, seed int64) []byte {
+	t.Helper()
+
+	corrupted := make([]byte, len(frame))
+	copy(corrupted, frame)
+
+	rng := rand.New(rand.NewSource(seed))
+	positions := rng.Perm(len(corrupted))[:n]
+	for _, pos := range positions {
+		var flip byte
+		for flip == 0 {
+			flip = byte(rng.Intn(256))
+		}
+		corrupted[pos] ^= flip
+	}
+
+	return corrupted
+}
+
+func TestRSCodecNoErrors(t *testing.T) {
+	rs := newRSCodec(rsDefaultDataSize, rsDefaultParitySize)
+	data := bytes.Repeat([]byte("spectre!"), rs.dataSize/8)
+
+	frame := rs.Encode(data)
+	got, corrected, err := rs.Decode(frame)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if corrected != 0 {
+		t.Fatalf("expected 0 corrected errors, got %d", corrected)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("decoded data does not match original")
+	}
+}
+
+func TestRSCodecSingleByteError(t *testing.T) {
+	rs := newRSCodec(rsDefaultDataSize, rsDefaultParitySize)
+	data := bytes.Repeat([]byte("spectre!"), rs.dataSize/8)
+
+	frame := rs.Encode(data)
+	corrupted := corruptN(t, rs, frame, 1, 1)
+
+	got, corrected, err := rs.Decode(corrupted)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if corrected != 1 {
+		t.Fatalf("expected 1 corrected error, got %d", corrected)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("decoded data does not match original")
+	}
+}
+
+// TestRSCodecMaxCorrectableErrors checks the RS(136,128) code's documented
+// correction capacity: 8 parity bytes correct up to 4 byte errors per frame.
+func TestRSCodecMaxCorrectableErrors(t *testing.T) {
+	rs := newRSCodec(rsDefaultDataSize, rsDefaultParitySize)
+	data := bytes.Repeat([]byte("spectre!"), rs.dataSize/8)
+
+	frame := rs.Encode(data)
+	corrupted := corruptN(t, rs, frame, 4, 2)
+
+	got, corrected, err := rs.Decode(corrupted)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if corrected != 4 {
+		t.Fatalf("expected 4 corrected errors, got %d", corrected)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("decoded data does not match original")
+	}
+}
+
+// TestRSCodecOverCapacityErrors checks that a frame with more byte errors
+// than the code can correct (5, for RS(136,128)) is reported as
+// uncorrectable rather than silently returning wrong data.
+func TestRSCodecOverCapacityErrors(t *testing.T) {
+	rs := newRSCodec(rsDefaultDataSize, rsDefaultParitySize)
+	data := bytes.Repeat([]byte("spectre!"), rs.dataSize/8)
+
+	frame := rs.Encode(data)
+	corrupted := corruptN(t, rs, frame, 5, 3)
+
+	if _, _, err := rs.Decode(corrupted); err == nil {
+		t.Fatal("expected an error decoding a frame with 5 byte errors, got nil")
+	}
+}
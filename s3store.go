@@ -0,0 +1,486 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+)
+
+// S3PasteStore is a PasteStore backed by any S3-compatible endpoint via
+// minio-go. Paste bodies are stored as objects named by PasteID; paste
+// metadata (language, hmac, encryption_version, kdf/rs fields) is stored as
+// S3 user metadata (x-amz-meta-* headers) instead of xattrs.
+type S3PasteStore struct {
+	PasteUpdateCallback  PasteCallback
+	PasteDestroyCallback PasteCallback
+
+	client *minio.Client
+	bucket string
+	secure bool
+
+	durable bool
+	rs      *rsCodec
+
+	sse encrypt.ServerSide
+
+	// pendingMu guards pending: writeStream and Save run concurrently for
+	// different pastes on a web server handling multiple requests, and
+	// s3Writer.Close deletes its paste's entry from another goroutine once
+	// the upload finishes.
+	pendingMu sync.Mutex
+
+	// pending holds metadata staged by Save for pastes whose writeStream
+	// hasn't uploaded an object yet: PasteWriter.Close calls Save before
+	// closing the underlying writer, so the only place left to carry that
+	// metadata to the eventual PutObject call is here.
+	pending map[PasteID]*s3MetadataStore
+}
+
+// pendingMetadata returns the staged metadata for a paste whose object
+// hasn't been uploaded yet, if any.
+func (store *S3PasteStore) pendingMetadata(id PasteID) (*s3MetadataStore, bool) {
+	store.pendingMu.Lock()
+	defer store.pendingMu.Unlock()
+	ms, ok := store.pending[id]
+	return ms, ok
+}
+
+// ensurePendingMetadata returns the staged metadata store for a paste,
+// creating an empty one if writeStream hasn't registered it yet.
+func (store *S3PasteStore) ensurePendingMetadata(id PasteID) *s3MetadataStore {
+	store.pendingMu.Lock()
+	defer store.pendingMu.Unlock()
+	ms, ok := store.pending[id]
+	if !ok {
+		ms = newS3MetadataStore(nil)
+		store.pending[id] = ms
+	}
+	return ms
+}
+
+func (store *S3PasteStore) clearPendingMetadata(id PasteID) {
+	store.pendingMu.Lock()
+	defer store.pendingMu.Unlock()
+	delete(store.pending, id)
+}
+
+// S3PasteStoreOption configures optional behavior of an S3PasteStore, such
+// as server-side encryption or durable (Reed-Solomon protected) storage.
+type S3PasteStoreOption func(*S3PasteStore)
+
+// WithS3ServerSideEncryption enables server-side encryption (SSE-S3 or
+// SSE-KMS, depending on sse) for every object this store reads and writes.
+func WithS3ServerSideEncryption(sse encrypt.ServerSide) S3PasteStoreOption {
+	return func(store *S3PasteStore) {
+		store.sse = sse
+	}
+}
+
+// WithS3Insecure connects to endpoint over plain HTTP instead of TLS, for
+// endpoints that don't terminate TLS themselves, such as a MinIO container
+// running on localhost in tests.
+func WithS3Insecure() S3PasteStoreOption {
+	return func(store *S3PasteStore) {
+		store.secure = false
+	}
+}
+
+// WithS3DurableStorage enables Reed-Solomon protected storage using the
+// default RS(136,128) shard sizes, same as WithDurableStorage does for
+// FilesystemPasteStore.
+func WithS3DurableStorage() S3PasteStoreOption {
+	return WithS3DurableStorageShards(rsDefaultDataSize, rsDefaultParitySize)
+}
+
+// WithS3DurableStorageShards enables Reed-Solomon protected storage with a
+// custom data/parity shard split.
+func WithS3DurableStorageShards(dataSize, paritySize int) S3PasteStoreOption {
+	return func(store *S3PasteStore) {
+		store.durable = true
+		store.rs = newRSCodec(dataSize, paritySize)
+	}
+}
+
+// NewS3PasteStore creates a PasteStore backed by the given S3-compatible
+// endpoint and bucket, which must already exist. The endpoint is assumed to
+// terminate TLS unless WithS3Insecure is given.
+func NewS3PasteStore(endpoint, bucket string, creds *credentials.Credentials, opts ...S3PasteStoreOption) (*S3PasteStore, error) {
+	store := &S3PasteStore{
+		bucket:               bucket,
+		secure:               true,
+		PasteUpdateCallback:  PasteCallback(noopPasteCallback),
+		PasteDestroyCallback: PasteCallback(noopPasteCallback),
+		pending:              map[PasteID]*s3MetadataStore{},
+	}
+
+	for _, opt := range opts {
+		opt(store)
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: store.secure,
+	})
+	if err != nil {
+		return nil, err
+	}
+	store.client = client
+
+	return store, nil
+}
+
+// SetLifecyclePolicy installs a bucket lifecycle rule (e.g. expire pastes
+// after N days), the S3 equivalent of a cron job sweeping the filesystem
+// store's directory.
+func (store *S3PasteStore) SetLifecyclePolicy(config *lifecycle.Configuration) error {
+	return store.client.SetBucketLifecycle(context.Background(), store.bucket, config)
+}
+
+func (store *S3PasteStore) New(id PasteID, key []byte) (p *Paste, err error) {
+	p = &Paste{ID: id, store: store}
+
+	if key != nil {
+		p.Encrypted = true
+		p.encryptionKey = key
+	}
+
+	return
+}
+
+func (store *S3PasteStore) NewWithPassphrase(id PasteID, passphrase string) (*Paste, error) {
+	return newWithPassphrase(store, id, passphrase)
+}
+
+func (store *S3PasteStore) GetWithPassphrase(id PasteID, passphrase string) (*Paste, error) {
+	ms, err := store.statMetadata(id)
+	if err != nil {
+		return nil, err
+	}
+	return getWithPassphrase(store, ms, id, passphrase)
+}
+
+func (store *S3PasteStore) NewWithRecipients(id PasteID, recipients []Recipient) (*Paste, error) {
+	return newWithRecipients(store, id, recipients)
+}
+
+func (store *S3PasteStore) GetWithIdentity(id PasteID, identity Identity) (p *Paste, err error) {
+	obj, err := store.client.GetObject(context.Background(), store.bucket, id.String(), minio.GetObjectOptions{ServerSideEncryption: store.sse})
+	if err != nil {
+		return nil, PasteNotFoundError{ID: id}
+	}
+	defer obj.Close()
+
+	ephemeralPub, stanzas, err := readRecipientHeader(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	fileKey, err := identity.Unwrap(ephemeralPub, stanzas)
+	if err != nil {
+		return nil, PasteInvalidKeyError{ID: id}
+	}
+
+	return store.Get(id, fileKey)
+}
+
+// s3MetadataStore is the metadataStore backing S3PasteStore. existing holds
+// a snapshot of an object's current user metadata; pending holds values
+// written through putMetadata, read back preferentially so a Save/Get pair
+// against the same store sees its own writes before they're uploaded. A
+// store kept in S3PasteStore.pending can be written by one request's Save
+// while another concurrently reads it via Get/GetWithPassphrase, so access
+// to pending is mutex-guarded.
+type s3MetadataStore struct {
+	mu       sync.Mutex
+	existing map[string]string
+	pending  map[string]string
+}
+
+func newS3MetadataStore(existing map[string]string) *s3MetadataStore {
+	return &s3MetadataStore{existing: existing, pending: map[string]string{}}
+}
+
+func (ms *s3MetadataStore) putMetadata(name, value string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.pending[name] = value
+	return nil
+}
+
+func (ms *s3MetadataStore) getMetadata(name, dflt string) string {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if v, ok := ms.pending[name]; ok {
+		return v
+	}
+	if v, ok := ms.existing[name]; ok {
+		return v
+	}
+	return dflt
+}
+
+// merged combines existing and pending into the UserMetadata map for a
+// PutObject/CopyObject call that finalizes a paste's object.
+func (ms *s3MetadataStore) merged() map[string]string {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	out := make(map[string]string, len(ms.existing)+len(ms.pending))
+	for k, v := range ms.existing {
+		out[k] = v
+	}
+	for k, v := range ms.pending {
+		out[k] = v
+	}
+	return out
+}
+
+// userMetadataFromHeader recovers the x-amz-meta-* values a generic
+// S3-compatible server echoes back on GetObject/StatObject, since
+// ObjectInfo.UserMetadata is populated only by MinIO servers. http.Header
+// canonicalizes keys (e.g. "hmac" becomes "X-Amz-Meta-Hmac"), so the
+// remaining name is lower-cased to match the keys savePasteMetadata/
+// s3MetadataStore write and look up.
+func userMetadataFromHeader(h http.Header) map[string]string {
+	const prefix = "X-Amz-Meta-"
+	out := map[string]string{}
+	for k, v := range h {
+		if len(v) == 0 {
+			continue
+		}
+		if strings.HasPrefix(strings.ToLower(k), strings.ToLower(prefix)) {
+			out[strings.ToLower(k[len(prefix):])] = v[0]
+		}
+	}
+	return out
+}
+
+// statMetadata fetches an object's current user metadata, or returns the
+// pending-only store staged by an in-flight Save if the object hasn't been
+// uploaded yet.
+func (store *S3PasteStore) statMetadata(id PasteID) (*s3MetadataStore, error) {
+	if ms, ok := store.pendingMetadata(id); ok {
+		return ms, nil
+	}
+
+	info, err := store.client.StatObject(context.Background(), store.bucket, id.String(), minio.StatObjectOptions{ServerSideEncryption: store.sse})
+	if err != nil {
+		return nil, PasteNotFoundError{ID: id}
+	}
+
+	return newS3MetadataStore(userMetadataFromHeader(info.Metadata)), nil
+}
+
+func (store *S3PasteStore) Get(id PasteID, key []byte) (p *Paste, err error) {
+	info, err := store.client.StatObject(context.Background(), store.bucket, id.String(), minio.StatObjectOptions{ServerSideEncryption: store.sse})
+	if err != nil {
+		return nil, PasteNotFoundError{ID: id}
+	}
+
+	ms := newS3MetadataStore(userMetadataFromHeader(info.Metadata))
+
+	paste := &Paste{ID: id, store: store, mtime: info.LastModified}
+
+	paste.Encrypted, paste.encryptionKey, err = checkEncryptedMetadata(ms, id, key)
+	if err != nil {
+		return nil, err
+	}
+
+	paste.Language = ms.getMetadata("language", "text")
+
+	store.PasteUpdateCallback(paste)
+
+	return paste, nil
+}
+
+// Save stages a paste's metadata. If its object hasn't been uploaded yet
+// (the normal new-paste flow, where PasteWriter.Close calls Save before the
+// underlying writer's Close actually performs the PutObject), the metadata
+// is simply held in store.pending for writeStream's writer to pick up.
+// Otherwise it's applied to the existing object in place via CopyObject,
+// the S3 equivalent of rewriting a file's xattrs.
+func (store *S3PasteStore) Save(p *Paste) error {
+	if ms, ok := store.pendingMetadata(p.ID); ok {
+		if err := savePasteMetadata(ms, p, store.durable); err != nil {
+			return err
+		}
+		store.PasteUpdateCallback(p)
+		return nil
+	}
+
+	info, err := store.client.StatObject(context.Background(), store.bucket, p.ID.String(), minio.StatObjectOptions{ServerSideEncryption: store.sse})
+	if err != nil {
+		return PasteNotFoundError{ID: p.ID}
+	}
+
+	ms := newS3MetadataStore(userMetadataFromHeader(info.Metadata))
+	if err := savePasteMetadata(ms, p, store.durable); err != nil {
+		return err
+	}
+
+	_, err = store.client.CopyObject(context.Background(),
+		minio.CopyDestOptions{
+			Bucket:          store.bucket,
+			Object:          p.ID.String(),
+			Encryption:      store.sse,
+			UserMetadata:    ms.merged(),
+			ReplaceMetadata: true,
+		},
+		minio.CopySrcOptions{
+			Bucket:     store.bucket,
+			Object:     p.ID.String(),
+			Encryption: store.sse,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	store.PasteUpdateCallback(p)
+	return nil
+}
+
+func (store *S3PasteStore) Destroy(p *Paste) error {
+	err := store.client.RemoveObject(context.Background(), store.bucket, p.ID.String(), minio.RemoveObjectOptions{})
+	if err != nil {
+		return err
+	}
+
+	store.PasteDestroyCallback(p)
+	return nil
+}
+
+func (store *S3PasteStore) readStream(p *Paste) (*PasteReader, error) {
+	obj, err := store.client.GetObject(context.Background(), store.bucket, p.ID.String(), minio.GetObjectOptions{ServerSideEncryption: store.sse})
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, PasteNotFoundError{ID: p.ID}
+	}
+	ms := newS3MetadataStore(userMetadataFromHeader(info.Metadata))
+
+	var r io.ReadCloser = obj
+
+	if ms.getMetadata("recipients", "") == "1" {
+		if _, _, err := readRecipientHeader(r); err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+
+	if store.durable && ms.getMetadata("rs", "") == "1" {
+		length, _ := strconv.Atoi(ms.getMetadata("rs_length", "0"))
+		r = newDurableReader(r, store.rs, length)
+	}
+
+	if p.Encrypted && ms.getMetadata("encryption_version", "1") == "2" {
+		sr, err := newStreamReader(r, deriveFileKey(p.encryptionKey))
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r = sr
+	}
+
+	return &PasteReader{ReadCloser: r, paste: p}, nil
+}
+
+// s3Writer streams a paste's body straight into a single multipart
+// PutObject call via an io.Pipe, rather than buffering the whole body on
+// local disk first: a goroutine started at construction time feeds the pipe
+// reader to PutObject while Write hands it bytes as they arrive from the
+// caller. Size is passed as -1 since the final length isn't known upfront,
+// which makes minio-go negotiate a streaming multipart upload.
+//
+// Its PutObject call uses whatever metadata Save had staged into
+// store.pending at construction time, which is usually none: Save is only
+// called once all of a paste's bytes are written, by which point this
+// upload is already underway. PasteWriter.Close's post-Close Save corrects
+// the object's metadata afterwards via Save's CopyObject path.
+type s3Writer struct {
+	store  *S3PasteStore
+	paste  *Paste
+	pw     *io.PipeWriter
+	result chan error
+	closed bool
+}
+
+func newS3Writer(store *S3PasteStore, p *Paste) (*s3Writer, error) {
+	// Snapshot the metadata before starting the upload goroutine: Save may
+	// mutate the same *s3MetadataStore concurrently with the upload once
+	// writing begins, and merged() must not race with that.
+	initialMetadata := store.ensurePendingMetadata(p.ID).merged()
+
+	pr, pw := io.Pipe()
+	w := &s3Writer{store: store, paste: p, pw: pw, result: make(chan error, 1)}
+
+	go func() {
+		_, err := store.client.PutObject(context.Background(), store.bucket, p.ID.String(), pr, -1, minio.PutObjectOptions{
+			UserMetadata:         initialMetadata,
+			ServerSideEncryption: store.sse,
+		})
+		pr.CloseWithError(err)
+		w.result <- err
+	}()
+
+	return w, nil
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *s3Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer w.store.clearPendingMetadata(w.paste.ID)
+
+	if err := w.pw.Close(); err != nil {
+		return err
+	}
+	return <-w.result
+}
+
+func (store *S3PasteStore) writeStream(p *Paste) (*PasteWriter, error) {
+	base, err := newS3Writer(store, p)
+	if err != nil {
+		return nil, err
+	}
+
+	var w io.WriteCloser = base
+
+	if p.recipientHeader != nil {
+		if _, err := w.Write(p.recipientHeader); err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+
+	if store.durable {
+		w = newDurableWriter(w, store.rs, p)
+	}
+
+	if p.Encrypted {
+		sw, err := newStreamWriter(w, deriveFileKey(p.encryptionKey))
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		w = sw
+	}
+
+	return &PasteWriter{WriteCloser: w, paste: p}, nil
+}
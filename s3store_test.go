@@ -0,0 +1,269 @@
+//go:build integration
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// These tests exercise S3PasteStore against a real MinIO server instead of
+// mocking minio-go, since the bugs this backend is prone to (metadata
+// round-tripping through actual HTTP headers, concurrent access to
+// store.pending) don't reproduce against a fake client. They're gated
+// behind the "integration" build tag and need a working "docker" on PATH:
+//
+//	go test -tags integration -run TestS3 ./...
+const (
+	minioTestBucket = "spectre-test"
+	minioTestAccess = "spectreminio"
+	minioTestSecret = "spectreminiosecret"
+)
+
+// startMinIO launches a throwaway MinIO container bound to a free local
+// port and returns its endpoint ("127.0.0.1:PORT") along with a cleanup
+// func that stops and removes the container.
+func startMinIO(t *testing.T) (endpoint string, cleanup func()) {
+	t.Helper()
+
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker not available, skipping MinIO integration test")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("allocating a port: %v", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	name := fmt.Sprintf("spectre-minio-test-%d", port)
+	cmd := exec.Command("docker", "run", "--rm", "-d",
+		"--name", name,
+		"-p", fmt.Sprintf("%d:9000", port),
+		"-e", "MINIO_ROOT_USER="+minioTestAccess,
+		"-e", "MINIO_ROOT_PASSWORD="+minioTestSecret,
+		"minio/minio", "server", "/data")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("starting MinIO container: %v: %s", err, out)
+	}
+
+	endpoint = fmt.Sprintf("127.0.0.1:%d", port)
+	cleanup = func() {
+		exec.Command("docker", "stop", name).Run()
+	}
+
+	creds := credentials.NewStaticV4(minioTestAccess, minioTestSecret, "")
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		client, err := minio.New(endpoint, &minio.Options{Creds: creds, Secure: false})
+		if err == nil {
+			if _, err := client.BucketExists(context.Background(), minioTestBucket); err == nil {
+				break
+			}
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{Creds: creds, Secure: false})
+	if err != nil {
+		cleanup()
+		t.Fatalf("minio.New: %v", err)
+	}
+	if err := client.MakeBucket(context.Background(), minioTestBucket, minio.MakeBucketOptions{}); err != nil {
+		exists, existsErr := client.BucketExists(context.Background(), minioTestBucket)
+		if existsErr != nil || !exists {
+			cleanup()
+			t.Fatalf("MakeBucket: %v", err)
+		}
+	}
+
+	return endpoint, cleanup
+}
+
+func newTestS3Store(t *testing.T, endpoint string, opts ...S3PasteStoreOption) *S3PasteStore {
+	t.Helper()
+
+	creds := credentials.NewStaticV4(minioTestAccess, minioTestSecret, "")
+	opts = append([]S3PasteStoreOption{WithS3Insecure()}, opts...)
+	store, err := NewS3PasteStore(endpoint, minioTestBucket, creds, opts...)
+	if err != nil {
+		t.Fatalf("NewS3PasteStore: %v", err)
+	}
+	return store
+}
+
+func writeAndClose(t *testing.T, p *Paste, body []byte) {
+	t.Helper()
+
+	w, err := p.Writer()
+	if err != nil {
+		t.Fatalf("Writer: %v", err)
+	}
+	if _, err := w.Write(body); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func readAll(t *testing.T, p *Paste) []byte {
+	t.Helper()
+
+	r, err := p.Reader()
+	if err != nil {
+		t.Fatalf("Reader: %v", err)
+	}
+	defer r.Close()
+
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	return body
+}
+
+func TestS3PasteStorePlaintextRoundTrip(t *testing.T) {
+	endpoint, cleanup := startMinIO(t)
+	defer cleanup()
+	store := newTestS3Store(t, endpoint)
+
+	body := bytes.Repeat([]byte("plaintext paste "), 10000)
+
+	p, err := store.New(PasteID("plain1"), nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	p.Language = "go"
+	writeAndClose(t, p, body)
+
+	got, err := store.Get(PasteID("plain1"), nil)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Language != "go" {
+		t.Fatalf("Language = %q, want %q (metadata didn't round-trip)", got.Language, "go")
+	}
+	if got.Encrypted {
+		t.Fatal("plaintext paste reported as encrypted")
+	}
+
+	if gotBody := readAll(t, got); !bytes.Equal(gotBody, body) {
+		t.Fatal("round-tripped body mismatch")
+	}
+}
+
+// TestS3PasteStoreEncryptedRoundTrip guards against the metadata
+// case-folding bug where a fresh Get (after the object is already on the
+// server, not served from store.pending) saw hmac=="" and reported an
+// encrypted paste as plaintext.
+func TestS3PasteStoreEncryptedRoundTrip(t *testing.T) {
+	endpoint, cleanup := startMinIO(t)
+	defer cleanup()
+	store := newTestS3Store(t, endpoint)
+
+	key := bytes.Repeat([]byte{0x42}, 32)
+	body := bytes.Repeat([]byte("secret paste "), 10000)
+
+	p, err := store.New(PasteID("enc1"), key)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	writeAndClose(t, p, body)
+
+	// A second, independent store instance forces Get to hit the server
+	// instead of store.pending.
+	fresh := newTestS3Store(t, endpoint)
+
+	if _, err := fresh.Get(PasteID("enc1"), nil); err == nil {
+		t.Fatal("expected PasteEncryptedError when no key is given, got nil")
+	}
+
+	got, err := fresh.Get(PasteID("enc1"), key)
+	if err != nil {
+		t.Fatalf("Get with key: %v", err)
+	}
+	if !got.Encrypted {
+		t.Fatal("encrypted paste reported as plaintext")
+	}
+
+	if gotBody := readAll(t, got); !bytes.Equal(gotBody, body) {
+		t.Fatal("round-tripped body mismatch")
+	}
+}
+
+// TestS3PasteStoreDurableEncryptedRoundTrip exercises durable (Reed-Solomon)
+// storage layered under STREAM encryption, guarding against rs_length being
+// persisted before the encryption layer seals its final chunk.
+func TestS3PasteStoreDurableEncryptedRoundTrip(t *testing.T) {
+	endpoint, cleanup := startMinIO(t)
+	defer cleanup()
+	store := newTestS3Store(t, endpoint, WithS3DurableStorage())
+
+	key := bytes.Repeat([]byte{0x7a}, 32)
+	body := []byte("a paste shorter than one STREAM chunk")
+
+	p, err := store.New(PasteID("durable1"), key)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	writeAndClose(t, p, body)
+
+	got, err := store.Get(PasteID("durable1"), key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	if gotBody := readAll(t, got); !bytes.Equal(gotBody, body) {
+		t.Fatalf("round-tripped body mismatch: got %q, want %q", gotBody, body)
+	}
+}
+
+// TestS3PasteStoreConcurrentWrites guards store.pending against the data
+// race a web server hits in normal operation: many pastes being created at
+// once, each mutating the shared pending map from its own goroutine. Run
+// with -race to catch regressions.
+func TestS3PasteStoreConcurrentWrites(t *testing.T) {
+	endpoint, cleanup := startMinIO(t)
+	defer cleanup()
+	store := newTestS3Store(t, endpoint)
+
+	const n = 20
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := PasteID(fmt.Sprintf("concurrent-%d", i))
+			body := []byte(fmt.Sprintf("paste body %d", i))
+
+			p, err := store.New(id, nil)
+			if err != nil {
+				t.Errorf("New: %v", err)
+				return
+			}
+			writeAndClose(t, p, body)
+
+			got, err := store.Get(id, nil)
+			if err != nil {
+				t.Errorf("Get: %v", err)
+				return
+			}
+			if gotBody := readAll(t, got); !bytes.Equal(gotBody, body) {
+				t.Errorf("paste %d: round-trip mismatch", i)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// streamChunkSize is the plaintext size of each authenticated chunk, matching
+// age's STREAM construction.
+const streamChunkSize = 64 * 1024
+
+const noncePrefixSize = 16
+const streamNonceSize = noncePrefixSize + 4 + 1
+
+var errChunkAuth = errors.New("spectre: paste chunk failed authentication")
+var errShortChunk = errors.New("spectre: short read in non-final paste chunk")
+
+// deriveFileKey turns the caller-supplied encryption key into the 32-byte
+// AES-256 key used for the chunked stream, so callers aren't required to
+// hand us exactly 32 bytes of key material.
+func deriveFileKey(encryptionKey []byte) []byte {
+	key := sha256.Sum256(append([]byte("spectre-stream-file-key|"), encryptionKey...))
+	return key[:]
+}
+
+func streamNonce(prefix [noncePrefixSize]byte, index uint32, last bool) []byte {
+	nonce := make([]byte, streamNonceSize)
+	copy(nonce, prefix[:])
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], index)
+	if last {
+		nonce[streamNonceSize-1] = 1
+	}
+	return nonce
+}
+
+func newStreamAEAD(fileKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCMWithNonceSize(block, streamNonceSize)
+}
+
+// streamWriter encrypts plaintext into fixed-size chunks as described by
+// age's STREAM, each sealed under its own nonce derived from a random
+// per-file prefix, a big-endian chunk counter, and a final-chunk flag.
+type streamWriter struct {
+	w           io.WriteCloser
+	aead        cipher.AEAD
+	noncePrefix [noncePrefixSize]byte
+	buf         []byte
+	index       uint32
+	closed      bool
+}
+
+func newStreamWriter(w io.WriteCloser, fileKey []byte) (*streamWriter, error) {
+	aead, err := newStreamAEAD(fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sw := &streamWriter{w: w, aead: aead}
+	if _, err := rand.Read(sw.noncePrefix[:]); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(sw.noncePrefix[:]); err != nil {
+		return nil, err
+	}
+
+	return sw, nil
+}
+
+func (sw *streamWriter) sealChunk(data []byte, last bool) error {
+	nonce := streamNonce(sw.noncePrefix, sw.index, last)
+	sealed := sw.aead.Seal(nil, nonce, data, nil)
+	if _, err := sw.w.Write(sealed); err != nil {
+		return err
+	}
+	sw.index++
+	return nil
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	sw.buf = append(sw.buf, p...)
+	for len(sw.buf) > streamChunkSize {
+		if err := sw.sealChunk(sw.buf[:streamChunkSize], false); err != nil {
+			return 0, err
+		}
+		sw.buf = sw.buf[streamChunkSize:]
+	}
+	return len(p), nil
+}
+
+func (sw *streamWriter) Close() error {
+	if sw.closed {
+		return nil
+	}
+	sw.closed = true
+
+	if err := sw.sealChunk(sw.buf, true); err != nil {
+		return err
+	}
+	sw.buf = nil
+
+	return sw.w.Close()
+}
+
+// streamReader decrypts and authenticates the chunk stream produced by
+// streamWriter. It refuses to hand back plaintext for a chunk that fails
+// authentication or that is short without being the final chunk.
+type streamReader struct {
+	r           *bufio.Reader
+	closer      io.Closer
+	aead        cipher.AEAD
+	noncePrefix [noncePrefixSize]byte
+	index       uint32
+	plaintext   []byte
+	done        bool
+	err         error
+}
+
+func newStreamReader(r io.ReadCloser, fileKey []byte) (*streamReader, error) {
+	aead, err := newStreamAEAD(fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sr := &streamReader{
+		r:      bufio.NewReaderSize(r, streamChunkSize+aead.Overhead()),
+		closer: r,
+		aead:   aead,
+	}
+	if _, err := io.ReadFull(sr.r, sr.noncePrefix[:]); err != nil {
+		return nil, err
+	}
+
+	return sr, nil
+}
+
+// nextChunk reads and authenticates the next sealed chunk, peeking a single
+// byte past it to determine whether it's the final chunk before decrypting,
+// since the final-chunk flag is folded into the nonce.
+func (sr *streamReader) nextChunk() (plaintext []byte, last bool, err error) {
+	sealed := make([]byte, streamChunkSize+sr.aead.Overhead())
+	n, readErr := io.ReadFull(sr.r, sealed)
+	sealed = sealed[:n]
+
+	switch {
+	case readErr == io.EOF || readErr == io.ErrUnexpectedEOF:
+		last = true
+	case readErr != nil:
+		return nil, false, readErr
+	default:
+		if _, peekErr := sr.r.Peek(1); peekErr == io.EOF {
+			last = true
+		}
+	}
+
+	nonce := streamNonce(sr.noncePrefix, sr.index, last)
+	plaintext, err = sr.aead.Open(sealed[:0], nonce, sealed, nil)
+	if err != nil {
+		return nil, false, errChunkAuth
+	}
+
+	if !last && len(plaintext) != streamChunkSize {
+		return nil, false, errShortChunk
+	}
+
+	sr.index++
+	return plaintext, last, nil
+}
+
+func (sr *streamReader) Read(p []byte) (int, error) {
+	if sr.err != nil {
+		return 0, sr.err
+	}
+
+	for len(sr.plaintext) == 0 {
+		if sr.done {
+			sr.err = io.EOF
+			return 0, sr.err
+		}
+
+		plaintext, last, err := sr.nextChunk()
+		if err != nil {
+			sr.err = err
+			return 0, err
+		}
+
+		sr.plaintext = plaintext
+		sr.done = last
+	}
+
+	n := copy(p, sr.plaintext)
+	sr.plaintext = sr.plaintext[n:]
+	return n, nil
+}
+
+func (sr *streamReader) Close() error {
+	return sr.closer.Close()
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// nopCloser adapts a bytes.Buffer (or any io.Writer) to io.WriteCloser for
+// tests that only care about the bytes written, not file lifecycle.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func sealPaste(t *testing.T, key []byte, plaintext []byte) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	sw, err := newStreamWriter(nopCloser{buf}, key)
+	if err != nil {
+		t.Fatalf("newStreamWriter: %v", err)
+	}
+	if _, err := sw.Write(plaintext); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func openPaste(key []byte, sealed []byte) (*streamReader, error) {
+	return newStreamReader(ioutil.NopCloser(bytes.NewReader(sealed)), key)
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key := deriveFileKey([]byte("round-trip-key"))
+
+	for _, size := range []int{0, 1, streamChunkSize, streamChunkSize + 1, 3 * streamChunkSize} {
+		plaintext := make([]byte, size)
+		for i := range plaintext {
+			plaintext[i] = byte(i)
+		}
+
+		sealed := sealPaste(t, key, plaintext)
+
+		sr, err := openPaste(key, sealed)
+		if err != nil {
+			t.Fatalf("size %d: newStreamReader: %v", size, err)
+		}
+		got, err := ioutil.ReadAll(sr)
+		if err != nil {
+			t.Fatalf("size %d: ReadAll: %v", size, err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Fatalf("size %d: round-trip mismatch", size)
+		}
+	}
+}
+
+// TestStreamTruncation checks that dropping the final (sealing) chunk is
+// detected rather than silently returning a truncated prefix: the reader
+// must hit a short-read error instead of an early, unauthenticated EOF.
+func TestStreamTruncation(t *testing.T) {
+	key := deriveFileKey([]byte("truncation-key"))
+	plaintext := make([]byte, 2*streamChunkSize)
+	sealed := sealPaste(t, key, plaintext)
+
+	truncated := sealed[:len(sealed)-1]
+
+	sr, err := openPaste(key, truncated)
+	if err != nil {
+		t.Fatalf("newStreamReader: %v", err)
+	}
+	if _, err := ioutil.ReadAll(sr); err == nil {
+		t.Fatal("expected an error reading a truncated stream, got nil")
+	}
+}
+
+// TestStreamInteriorBitFlip checks that flipping a single bit anywhere in an
+// interior (non-final) chunk fails GCM authentication instead of decrypting
+// to corrupted plaintext.
+func TestStreamInteriorBitFlip(t *testing.T) {
+	key := deriveFileKey([]byte("bitflip-key"))
+	plaintext := make([]byte, 2*streamChunkSize+10)
+	sealed := sealPaste(t, key, plaintext)
+
+	flipped := make([]byte, len(sealed))
+	copy(flipped, sealed)
+	flipped[noncePrefixSize+streamChunkSize/2] ^= 0x01
+
+	sr, err := openPaste(key, flipped)
+	if err != nil {
+		t.Fatalf("newStreamReader: %v", err)
+	}
+	if _, err := ioutil.ReadAll(sr); err != errChunkAuth {
+		t.Fatalf("expected errChunkAuth, got %v", err)
+	}
+}
+
+// TestStreamReorderedChunks checks that swapping two complete, individually
+// well-formed chunks is caught: each chunk's index is folded into its AEAD
+// nonce, so a reordered chunk fails to authenticate under the index the
+// reader expects it at.
+func TestStreamReorderedChunks(t *testing.T) {
+	key := deriveFileKey([]byte("reorder-key"))
+	plaintext := make([]byte, 3*streamChunkSize)
+	sealed := sealPaste(t, key, plaintext)
+
+	chunkOnDisk := streamChunkSize + aeadOverhead(t, key)
+	first := sealed[noncePrefixSize : noncePrefixSize+chunkOnDisk]
+	second := sealed[noncePrefixSize+chunkOnDisk : noncePrefixSize+2*chunkOnDisk]
+
+	reordered := make([]byte, len(sealed))
+	copy(reordered, sealed)
+	copy(reordered[noncePrefixSize:], second)
+	copy(reordered[noncePrefixSize+chunkOnDisk:], first)
+
+	sr, err := openPaste(key, reordered)
+	if err != nil {
+		t.Fatalf("newStreamReader: %v", err)
+	}
+	if _, err := ioutil.ReadAll(sr); err != errChunkAuth {
+		t.Fatalf("expected errChunkAuth, got %v", err)
+	}
+}
+
+func aeadOverhead(t *testing.T, key []byte) int {
+	t.Helper()
+	aead, err := newStreamAEAD(key)
+	if err != nil {
+		t.Fatalf("newStreamAEAD: %v", err)
+	}
+	return aead.Overhead()
+}